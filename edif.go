@@ -0,0 +1,325 @@
+// Output an AST directly as an EDIF 2.0.0 netlist, bypassing the
+// Verilog->edif2qmasm step described in verilog.go's header comment.  It
+// mirrors WriteVerilog's structure -- one cell per top-level predicate
+// group, one $vN-equivalent validity net per clause, one $valid reduction OR
+// -- but lowers each operator straight to an AND2/OR2/XOR2/NOT/EQ (etc.)
+// primitive-cell instance instead of a Verilog expression, tagging every
+// instance with the source position of the Prolog term it came from.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// edifOp maps a Verilog-style operator, as already produced by the
+// prologToVerilog* tables in verilog.go, to the EDIF primitive cell that
+// implements it.  Division and mod are deliberately absent: the div_mod
+// submodule verilog.go instantiates for them has no EDIF equivalent yet.
+// ">>" maps to the logical (not arithmetic) shift primitive SHR, matching
+// the plain unsigned ">>" the Verilog backend lowers the same operator to.
+var edifOp = map[string]string{
+	"==": "EQ", "!=": "NEQ", "<": "LT", ">": "GT", "<=": "LE", ">=": "GE",
+	"+": "ADD", "-": "SUB", "*": "MULT",
+	"&": "AND2", "|": "OR2", "^": "XOR2", "~": "NOT",
+	">>": "SHR", "<<": "SHL",
+}
+
+// edifCtx tracks the running state WriteEDIF needs while flattening one
+// clause group to gate-level cells: p, needed to look up a symbol's integer
+// tag via symbolID, p2v maps Prolog variables to the module's input port
+// names, n generates unique instance/net names, and nets/netOrder
+// accumulate every net's portRefs so writeNets can declare each net once,
+// fully joined, after all of a group's instances have been emitted.
+type edifCtx struct {
+	w        io.Writer
+	p        *Parameters
+	p2v      map[string]string
+	n        int
+	nets     map[string][]string
+	netOrder []string
+}
+
+// join records that net (an interface port name or an instantiate-returned
+// net name) is electrically joined to portRef, a "(portRef ...)" form.  The
+// net isn't written out until writeNets, since a net created early (e.g., a
+// clause's own CONST) may pick up further portRefs later (e.g., the
+// group's final $valid connection).
+func (ctx *edifCtx) join(net, portRef string) {
+	if _, ok := ctx.nets[net]; !ok {
+		ctx.netOrder = append(ctx.netOrder, net)
+	}
+	ctx.nets[net] = append(ctx.nets[net], portRef)
+}
+
+// writeNets declares every net join has accumulated, each with the full set
+// of portRefs connected to it -- the cell interface's own portRef for a
+// primary input/output, plus a portRef per instance pin wired to it.  This
+// must run only once all of a group's instances have been instantiated, so
+// call it last.
+func (ctx *edifCtx) writeNets() {
+	for _, nm := range ctx.netOrder {
+		fmt.Fprintf(ctx.w, "        (net %s (joined %s))\n", nm, strings.Join(ctx.nets[nm], " "))
+	}
+}
+
+// instantiate emits one EDIF instance of the given primitive cell wired to
+// the given input nets, tagged with pos as a SOURCE_LINE property (and any
+// extraProps, additional "(property ...)" forms such as constNet's VALUE)
+// so a later debugging pass can map a misbehaving cell back to Prolog
+// source, and returns the net carrying its output.  The instance's pins
+// are not declared as nets directly; instantiate only records the joins,
+// via ctx.join, that writeNets later turns into well-formed EDIF "net"
+// declarations once every pin referencing a given net is known.
+func (ctx *edifCtx) instantiate(cellType string, ins []string, pos position, extraProps ...string) string {
+	ctx.n++
+	inst := fmt.Sprintf("inst%d", ctx.n)
+	out := fmt.Sprintf("net%d", ctx.n)
+	fmt.Fprintf(ctx.w, "        (instance %s (viewRef VIEW1 (cellRef %s (libraryRef PRIMS)))\n", inst, cellType)
+	fmt.Fprintf(ctx.w, "          (property SOURCE_LINE (integer %d))\n", pos.line)
+	for _, prop := range extraProps {
+		fmt.Fprintf(ctx.w, "          %s\n", prop)
+	}
+	fmt.Fprintf(ctx.w, "          (property SOURCE_COL (integer %d)))\n", pos.col)
+	ctx.join(out, fmt.Sprintf("(portRef OUT (instanceRef %s))", inst))
+	for i, in := range ins {
+		ctx.join(in, fmt.Sprintf("(portRef IN%d (instanceRef %s))", i, inst))
+	}
+	return out
+}
+
+// constNet instantiates a CONST cell holding val (a symbol's integer tag or
+// a numeral's value, per toEDIFNet) and returns the net carrying it, so
+// that literal head arguments and Prolog constants can be compared against
+// like any other net.
+func (ctx *edifCtx) constNet(val int, pos position) string {
+	return ctx.instantiate("CONST", nil, pos, fmt.Sprintf("(property VALUE (integer %d))", val))
+}
+
+// toEDIFNet recursively lowers an AST, starting from a clause's body
+// predicate, to a chain of primitive-cell instances, returning the net
+// that carries the resulting value.  It is EDIF's counterpart to
+// toVerilogExpr.
+func (a *ASTNode) toEDIFNet(ctx *edifCtx) string {
+	switch a.Type {
+	case NumeralType:
+		// A constant net driven by a CONST cell rather than a port.
+		return ctx.constNet(a.Value.(int), a.Pos)
+
+	case AtomType:
+		// A constant net driven by a CONST cell, tagged with the atom's
+		// integer symbol ID so it encodes the same way as writeSymbols'
+		// `define constants do for the Verilog backend.
+		return ctx.constNet(symbolID(a.Value.(string), ctx.p), a.Pos)
+
+	case VariableType:
+		v, ok := ctx.p2v[a.Value.(string)]
+		if !ok {
+			notify.Fatalf("Internal error: Failed to convert variable %s from Prolog to EDIF", a.Value.(string))
+		}
+		return v
+
+	case PrimaryExprType:
+		return a.Children[0].toEDIFNet(ctx)
+
+	case UnaryExprType:
+		if len(a.Children) == 1 {
+			return a.Children[0].toEDIFNet(ctx)
+		}
+		return ctx.unaryCell(a.Children[0], a.Children[1])
+
+	case AdditiveExprType:
+		if len(a.Children) == 1 {
+			return a.Children[0].toEDIFNet(ctx)
+		}
+		return ctx.binaryCell(prologToVerilogAdd, a.Children[0], a.Children[1], a.Children[2])
+
+	case MultiplicativeExprType:
+		if len(a.Children) == 1 {
+			return a.Children[0].toEDIFNet(ctx)
+		}
+		op := a.Children[1].Value.(string)
+		if dividingOps[op] {
+			notify.Fatalf("Internal error: the EDIF backend does not yet support %q; use -format verilog", op)
+		}
+		return ctx.binaryCell(prologToVerilogMult, a.Children[0], a.Children[1], a.Children[2])
+
+	case RelationType:
+		return ctx.binaryCell(prologToVerilogRel, a.Children[0], a.Children[1], a.Children[2])
+
+	case PredicateType, TermType:
+		return a.Children[0].toEDIFNet(ctx)
+
+	default:
+		notify.Fatalf("Internal error: Unexpected AST node type %s", a.Type)
+	}
+	return "" // We should never get here.
+}
+
+// unaryCell instantiates the primitive cell for a UnaryOpType node applied
+// to operand.
+func (ctx *edifCtx) unaryCell(opNode, operand *ASTNode) string {
+	op, ok := prologToVerilogUnary[opNode.Value.(string)]
+	if !ok {
+		notify.Fatalf("Internal error: Failed to convert %s %q from Prolog to EDIF", opNode.Type, opNode.Value.(string))
+	}
+	cell, ok := edifOp[op]
+	if !ok {
+		notify.Fatalf("Internal error: No EDIF primitive for operator %q", op)
+	}
+	in := operand.toEDIFNet(ctx)
+	if cell == "SUB" {
+		// Unary minus: 0 - operand.
+		return ctx.instantiate(cell, []string{ctx.constNet(0, opNode.Pos), in}, opNode.Pos)
+	}
+	return ctx.instantiate(cell, []string{in}, opNode.Pos)
+}
+
+// binaryCell instantiates the primitive cell for an AdditiveOpType,
+// MultiplicativeOpType, or RelationOpType node applied to lhs and rhs,
+// looking up the Prolog operator's text in the given prologToVerilog*
+// table to find its EDIF cell.
+func (ctx *edifCtx) binaryCell(table map[string]string, lhs, opNode, rhs *ASTNode) string {
+	op, ok := table[opNode.Value.(string)]
+	if !ok {
+		notify.Fatalf("Internal error: Failed to convert %s %q from Prolog to EDIF", opNode.Type, opNode.Value.(string))
+	}
+	cell, ok := edifOp[op]
+	if !ok {
+		notify.Fatalf("Internal error: No EDIF primitive for operator %q", op)
+	}
+	l := lhs.toEDIFNet(ctx)
+	r := rhs.toEDIFNet(ctx)
+	return ctx.instantiate(cell, []string{l, r}, opNode.Pos)
+}
+
+// writeEDIFClauseGroup writes an EDIF cell corresponding to a group of
+// clauses that share a name and arity, the same unit writeClauseGroup
+// compiles to a single Verilog module.
+func (a *ASTNode) writeEDIFClauseGroup(w io.Writer, p *Parameters, nm string, cs []*ASTNode) {
+	_, vArgs := cs[0].args()
+	widths := groupArgWidths(p, cs)
+
+	fmt.Fprintf(w, "    (cell %s (cellType GENERIC)\n", nm)
+	fmt.Fprintln(w, "      (view VIEW1 (viewType NETLIST)")
+	fmt.Fprintln(w, "        (interface")
+	for i, v := range vArgs {
+		fmt.Fprintf(w, "          (port %s (direction INPUT) (property WIDTH (integer %d)))\n", v, widths[i])
+	}
+	fmt.Fprintln(w, "          (port $valid (direction OUTPUT)))")
+	fmt.Fprintln(w, "        (contents")
+
+	// Share a single edifCtx across every clause and the $valid
+	// OR-reduction below so instance/net names stay unique across the
+	// whole (contents ...) view, the same reason writeClauseBody keys
+	// its wire/instance names off cNum.  Seed each input port's own net
+	// with its interface portRef so later joins (from EQ/toEDIFNet
+	// instances consuming it) accumulate onto the same net.
+	ctx := &edifCtx{w: w, p: p, nets: make(map[string][]string)}
+	for _, v := range vArgs {
+		ctx.join(v, fmt.Sprintf("(portRef %s)", v))
+	}
+
+	live := 0
+	clauseNets := make([]string, 0, len(cs))
+	for _, c := range cs {
+		if p.DeadClauses[c] {
+			continue
+		}
+		clauseNets = append(clauseNets, c.writeEDIFClauseBody(ctx, vArgs))
+		live++
+	}
+
+	var validNet string
+	if live == 0 {
+		validNet = ctx.constNet(0, cs[0].Pos)
+	} else {
+		validNet = clauseNets[0]
+		for _, n := range clauseNets[1:] {
+			validNet = ctx.instantiate("OR2", []string{validNet, n}, cs[0].Pos)
+		}
+	}
+	ctx.join(validNet, "(portRef $valid)")
+	ctx.writeNets()
+
+	fmt.Fprintln(w, "        )")
+	fmt.Fprintln(w, "      )")
+	fmt.Fprintln(w, "    )")
+}
+
+// writeEDIFClauseBody lowers one clause to a chain of primitive-cell
+// instances and returns the net carrying that clause's own validity bit
+// (the AND of every argument match and body goal), EDIF's counterpart to
+// the $vN wire writeClauseBody assigns in the Verilog backend.  ctx is
+// shared across every clause in the group so instance/net names stay
+// unique across the whole cell.
+func (c *ASTNode) writeEDIFClauseBody(ctx *edifCtx, vArgs []string) string {
+	terms := c.Children[0].Children[1:]
+	pArgs, _ := c.args()
+	p := ctx.p
+	p2v := make(map[string]string, len(pArgs))
+	ctx.p2v = p2v
+	conds := make([]string, 0, len(pArgs)+len(c.Children)-1)
+	for i, pa := range pArgs {
+		switch terms[i].Type {
+		case ListType, StructureType:
+			notify.Fatalf("Internal error: the EDIF backend does not yet support list/structure arguments; use -format verilog")
+		}
+		r0 := rune(pa[0])
+		switch {
+		case unicode.IsLower(r0):
+			// Symbol: match against its integer tag, the same encoding
+			// toEDIFNet's AtomType case uses.
+			conds = append(conds, ctx.instantiate("EQ", []string{vArgs[i], ctx.constNet(symbolID(pa, p), c.Pos)}, c.Pos))
+		case unicode.IsDigit(r0):
+			// Numeral
+			n, err := strconv.Atoi(pa)
+			if err != nil {
+				notify.Fatalf("Internal error: %q is not a valid numeral", pa)
+			}
+			conds = append(conds, ctx.instantiate("EQ", []string{vArgs[i], ctx.constNet(n, c.Pos)}, c.Pos))
+		case unicode.IsUpper(r0):
+			// Variable
+			if v, seen := p2v[pa]; seen {
+				conds = append(conds, ctx.instantiate("EQ", []string{vArgs[i], v}, c.Pos))
+			} else {
+				p2v[pa] = vArgs[i]
+			}
+		default:
+			notify.Fatalf("Internal error processing %q", pa)
+		}
+	}
+	for _, g := range c.Children[1:] {
+		conds = append(conds, g.toEDIFNet(ctx))
+	}
+	if len(conds) == 0 {
+		return ctx.constNet(1, c.Pos) // An unconstrained clause is trivially satisfiable.
+	}
+	out := conds[0]
+	for _, n := range conds[1:] {
+		out = ctx.instantiate("AND2", []string{out, n}, c.Pos)
+	}
+	return out
+}
+
+// WriteEDIF writes an entire (preprocessed) AST directly to an EDIF 2.0.0
+// netlist, one cell per top-level predicate group.
+func (a *ASTNode) WriteEDIF(w io.Writer, p *Parameters) {
+	fmt.Fprintf(w, "(edif QA_PROLOG\n")
+	fmt.Fprintln(w, "  (edifVersion 2 0 0)")
+	fmt.Fprintln(w, "  (edifLevel 0)")
+	fmt.Fprintln(w, "  (keywordMap (keywordLevel 0))")
+	fmt.Fprintf(w, "  (comment \"Direct EDIF lowering of Prolog program %s by %s\")\n", p.InFileName, p.ProgName)
+	fmt.Fprintln(w, "  (library QA_PROLOG_LIB")
+	fmt.Fprintln(w, "    (edifLevel 0)")
+	fmt.Fprintln(w, "    (technology (numberDefinition))")
+	for nm, cs := range p.TopLevel {
+		a.writeEDIFClauseGroup(w, p, nm, cs)
+	}
+	fmt.Fprintln(w, "  )")
+	fmt.Fprintln(w, ")")
+}
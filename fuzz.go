@@ -0,0 +1,461 @@
+// Mutation-based fuzzing harness for the Prolog-to-Verilog compiler.
+//
+// Fuzz generates random, well-typed Prolog ASTs restricted to the subset
+// WriteVerilog currently understands (atoms, numerals, variables, the
+// unary/additive/multiplicative/relational operators, and fixed-arity
+// clause groups), compiles each one, runs the compiled Verilog through an
+// external simulator (iverilog/vvp), and cross-checks the simulated
+// module's satisfying-answer set against a small reference evaluator for
+// the same subset.  Without a working simulator nothing is compared. On a
+// divergence it shrinks the offending AST before reporting it, the same
+// way a hardware fuzzer narrows down a synthesis-tool bug.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FuzzConfig controls one run of the fuzzing harness.
+type FuzzConfig struct {
+	Seed     int64  // Random-number seed, for reproducing a run
+	IntBits  uint   // Integer width to fuzz with
+	MaxArity int    // Largest clause arity to generate
+	Iverilog string // Path to iverilog, or "" to skip simulator cross-checking
+	Vvp      string // Path to vvp, or "" to skip simulator cross-checking
+}
+
+// mulOps and addOps list the multiplicative/additive operators randExpr
+// draws from -- deliberately excluding "/", "//", and "mod" so the
+// reference evaluator does not have to special-case division by zero.
+var mulOps = []string{"*", "/\\", ">>", "<<"}
+var addOps = []string{"+", "-", "\\/", "xor"}
+var unaryOps = []string{"-", "\\"}
+var relOps = []string{"<=", ">=", "<", ">", "=", "\\="}
+
+// headAtoms lists the symbols randClauseGroup may plant as a ground head
+// argument, so the fuzzer also drives process()'s (and its EDIF
+// counterpart's) symbol-matching branch, not just variable unification.
+var headAtoms = []string{"a", "b"}
+
+// Fuzz runs n random-testing iterations, each generating, compiling, and
+// checking one small clause group, and reports the first divergence it
+// finds (after shrinking it to a minimal counterexample) to stderr.
+func Fuzz(n int, cfg FuzzConfig) {
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	simRan := false
+	for i := 0; i < n; i++ {
+		p := &Parameters{ProgName: "qa-prolog-fuzz", InFileName: "<fuzz>", IntBits: cfg.IntBits}
+		nm, cs := randClauseGroup(rng, p, cfg)
+		div, sim := diverges(nm, cs, p, cfg)
+		simRan = simRan || sim
+		if div != "" {
+			nm, cs = shrink(nm, cs, p, cfg)
+			fmt.Fprintf(os.Stderr, "qa-prolog: fuzzing found a divergence after %d iteration(s):\n", i+1)
+			fmt.Fprintln(os.Stderr, prologSyntax(nm, cs))
+			div, _ = diverges(nm, cs, p, cfg)
+			fmt.Fprintln(os.Stderr, div)
+			return
+		}
+	}
+	if (cfg.Iverilog != "" || cfg.Vvp != "") && !simRan {
+		fmt.Fprintf(os.Stderr, "qa-prolog: warning: never managed to run %s/%s; no answer sets were cross-checked\n", cfg.Iverilog, cfg.Vvp)
+	}
+	fmt.Fprintf(os.Stderr, "qa-prolog: %d fuzzing iteration(s) found no divergence\n", n)
+}
+
+// diverges compiles clause group nm/cs, evaluates it two independent ways
+// (a reference Go evaluator, and -- when cfg names a working iverilog/vvp
+// pair -- an external simulator), and returns a non-empty description of
+// the mismatch if the two answer sets disagree.  Its second result reports
+// whether the simulator cross-check actually ran, so a caller can tell "no
+// divergence found" apart from "the toolchain was never available to check
+// against" instead of treating the latter as a silent pass.
+func diverges(nm string, cs []*ASTNode, p *Parameters, cfg FuzzConfig) (mismatch string, simRan bool) {
+	want := referenceAnswers(nm, cs, p)
+	if cfg.Iverilog == "" || cfg.Vvp == "" {
+		return "", false
+	}
+	got, err := simulate(nm, cs, p, cfg)
+	if err != nil {
+		return "", false // A missing/broken toolchain isn't a compiler bug.
+	}
+	if answersEqual(want, got) {
+		return "", true
+	}
+	return fmt.Sprintf("reference evaluator says %v; simulator says %v", want, got), true
+}
+
+// randClauseGroup generates a single random clause defining a predicate of
+// a random name and arity from the AST subset WriteVerilog supports.  Each
+// head argument is, at random, a fresh variable, a ground symbol, or a
+// ground numeral, so the generated clauses exercise process()'s (and the
+// EDIF backend's) symbol/numeral head-literal matches as well as plain
+// unification.
+func randClauseGroup(rng *rand.Rand, p *Parameters, cfg FuzzConfig) (string, []*ASTNode) {
+	arity := 1 + rng.Intn(cfg.MaxArity)
+	nm := fmt.Sprintf("fuzz%d", rng.Intn(1<<20))
+	vars := make([]string, 0, arity)
+	head := &ASTNode{Type: PredicateType, Children: []*ASTNode{{Type: AtomType, Value: nm}}}
+	for i := 0; i < arity; i++ {
+		switch rng.Intn(5) {
+		case 0:
+			a := headAtoms[rng.Intn(len(headAtoms))]
+			head.Children = append(head.Children, &ASTNode{Type: AtomType, Value: a, Text: a})
+		case 1:
+			n := rng.Intn(1 << cfg.IntBits)
+			head.Children = append(head.Children, &ASTNode{Type: NumeralType, Value: n, Text: strconv.Itoa(n)})
+		default:
+			v := string(rune('A' + i))
+			vars = append(vars, v)
+			head.Children = append(head.Children, &ASTNode{Type: VariableType, Value: v, Text: v})
+		}
+	}
+	clause := &ASTNode{Type: ClauseType, Children: []*ASTNode{head}}
+	for j := 0; j < rng.Intn(3); j++ {
+		clause.Children = append(clause.Children, randGoal(rng, vars, 2))
+	}
+	return nm, []*ASTNode{clause}
+}
+
+// randGoal generates a random relational body goal over the given
+// in-scope variables, bottoming out at depth 0 to keep terms finite.
+func randGoal(rng *rand.Rand, vars []string, depth int) *ASTNode {
+	lhs := randExpr(rng, vars, depth)
+	rhs := randExpr(rng, vars, depth)
+	op := relOps[rng.Intn(len(relOps))]
+	return &ASTNode{Type: RelationType, Children: []*ASTNode{
+		lhs,
+		{Type: RelationOpType, Value: op},
+		rhs,
+	}}
+}
+
+// randExpr generates a random arithmetic expression tree, terminating in a
+// numeral or a randomly chosen in-scope variable once depth reaches 0.
+func randExpr(rng *rand.Rand, vars []string, depth int) *ASTNode {
+	if depth <= 0 || rng.Intn(3) == 0 {
+		if len(vars) > 0 && rng.Intn(2) == 0 {
+			v := vars[rng.Intn(len(vars))]
+			return &ASTNode{Type: VariableType, Value: v, Text: v}
+		}
+		n := rng.Intn(8)
+		return &ASTNode{Type: NumeralType, Value: n, Text: strconv.Itoa(n)}
+	}
+	if rng.Intn(4) == 0 {
+		op := unaryOps[rng.Intn(len(unaryOps))]
+		operand := randExpr(rng, vars, depth-1)
+		return &ASTNode{Type: UnaryExprType, Children: []*ASTNode{{Type: UnaryOpType, Value: op}, operand}}
+	}
+	c1 := randExpr(rng, vars, depth-1)
+	c2 := randExpr(rng, vars, depth-1)
+	if rng.Intn(2) == 0 {
+		op := addOps[rng.Intn(len(addOps))]
+		return &ASTNode{Type: AdditiveExprType, Children: []*ASTNode{c1, {Type: AdditiveOpType, Value: op}, c2}}
+	}
+	op := mulOps[rng.Intn(len(mulOps))]
+	return &ASTNode{Type: MultiplicativeExprType, Children: []*ASTNode{c1, {Type: MultiplicativeOpType, Value: op}, c2}}
+}
+
+// simulate compiles clause group nm/cs to Verilog, appends a testbench,
+// runs it through iverilog and vvp, and returns the sorted set of
+// satisfying answers the simulator printed.
+func simulate(nm string, cs []*ASTNode, p *Parameters, cfg FuzzConfig) ([]string, error) {
+	dummy := &ASTNode{}
+	var vlog bytes.Buffer
+	dummy.writeSymbols(&vlog, p)
+	dummy.writeClauseGroup(&vlog, p, nm, cs)
+	var tb bytes.Buffer
+	dummy.writeTestbenchGroup(&tb, p, nm, cs)
+
+	srcFile, err := os.CreateTemp("", "qa-prolog-fuzz-*.v")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(srcFile.Name())
+	fmt.Fprintln(srcFile, vlog.String())
+	fmt.Fprintln(srcFile, "module testbench;")
+	fmt.Fprintln(srcFile, tb.String())
+	steps := uint64(1) // The testbench advances time by 1 per input combination.
+	for range cs[0].Children[0].Children[1:] {
+		steps *= vectorLimit(p)
+	}
+	fmt.Fprintf(srcFile, "initial #%d $finish;\n", steps+1)
+	fmt.Fprintln(srcFile, "endmodule")
+	srcFile.Close()
+
+	outFile := srcFile.Name() + ".vvp"
+	defer os.Remove(outFile)
+	if out, err := exec.Command(cfg.Iverilog, "-o", outFile, srcFile.Name()).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("iverilog: %s: %w", out, err)
+	}
+	out, err := exec.Command(cfg.Vvp, outFile).Output()
+	if err != nil {
+		return nil, fmt.Errorf("vvp: %w", err)
+	}
+	answers := make([]string, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, ").") {
+			answers = append(answers, line)
+		}
+	}
+	sort.Strings(answers)
+	return answers, nil
+}
+
+// referenceAnswers evaluates clause group nm/cs directly in Go, exhaustively
+// trying every combination of IntBits-wide inputs and recording each one
+// that satisfies every body goal, in the same "name(args)." syntax
+// writeTestbenchGroup's $display lines use. It exists to cross-check the
+// compiler's own semantics independently of the Verilog it emits.
+func referenceAnswers(nm string, cs []*ASTNode, p *Parameters) []string {
+	c := cs[0]
+	head := c.Children[0]
+	arity := len(head.Children) - 1
+	dom := 1 << p.IntBits
+	answers := make([]string, 0)
+	vals := make([]int, arity)
+	var iterate func(i int)
+	iterate = func(i int) {
+		if i == arity {
+			bind := make(map[string]int, arity)
+			ok := true
+			for j, t := range head.Children[1:] {
+				switch t.Type {
+				case VariableType:
+					bind[t.Value.(string)] = vals[j]
+				case AtomType:
+					if vals[j] != symbolID(t.Value.(string), p) {
+						ok = false
+					}
+				case NumeralType:
+					if vals[j] != t.Value.(int) {
+						ok = false
+					}
+				}
+			}
+			for gi := 1; ok && gi < len(c.Children); gi++ {
+				if !evalGoal(c.Children[gi], bind, p.IntBits) {
+					ok = false
+				}
+			}
+			if ok {
+				strs := make([]string, arity)
+				for j, v := range vals {
+					strs[j] = strconv.Itoa(v)
+				}
+				answers = append(answers, fmt.Sprintf("%s(%s).", nm, strings.Join(strs, ", ")))
+			}
+			return
+		}
+		for v := 0; v < dom; v++ {
+			vals[i] = v
+			iterate(i + 1)
+		}
+	}
+	iterate(0)
+	sort.Strings(answers)
+	return answers
+}
+
+// evalGoal evaluates one relational body goal over a ground variable
+// binding, wrapping every intermediate result to intBits bits (unsigned
+// intBits-bit modular arithmetic).
+func evalGoal(g *ASTNode, bind map[string]int, intBits uint) bool {
+	lhs := evalExpr(g.Children[0], bind, intBits)
+	rhs := evalExpr(g.Children[2], bind, intBits)
+	switch g.Children[1].Value.(string) {
+	case "<=":
+		return lhs <= rhs
+	case ">=":
+		return lhs >= rhs
+	case "<":
+		return lhs < rhs
+	case ">":
+		return lhs > rhs
+	case "=":
+		return lhs == rhs
+	case "\\=":
+		return lhs != rhs
+	default:
+		notify.Fatalf("Internal error: fuzzer generated unknown relation %q", g.Children[1].Value)
+	}
+	return false // Unreachable.
+}
+
+// evalExpr evaluates an arithmetic expression over a ground variable
+// binding, masking the result to intBits bits after every operation.
+func evalExpr(e *ASTNode, bind map[string]int, intBits uint) int {
+	mask := (1 << intBits) - 1
+	switch e.Type {
+	case NumeralType:
+		return e.Value.(int) & mask
+	case VariableType:
+		return bind[e.Value.(string)]
+	case UnaryExprType:
+		if len(e.Children) == 1 {
+			return evalExpr(e.Children[0], bind, intBits)
+		}
+		v := evalExpr(e.Children[1], bind, intBits)
+		switch e.Children[0].Value.(string) {
+		case "-":
+			return (-v) & mask
+		case "\\":
+			return (^v) & mask
+		}
+	case AdditiveExprType:
+		if len(e.Children) == 1 {
+			return evalExpr(e.Children[0], bind, intBits)
+		}
+		l, r := evalExpr(e.Children[0], bind, intBits), evalExpr(e.Children[2], bind, intBits)
+		switch e.Children[1].Value.(string) {
+		case "+":
+			return (l + r) & mask
+		case "-":
+			return (l - r) & mask
+		case "\\/":
+			return (l | r) & mask
+		case "xor":
+			return (l ^ r) & mask
+		}
+	case MultiplicativeExprType:
+		if len(e.Children) == 1 {
+			return evalExpr(e.Children[0], bind, intBits)
+		}
+		l, r := evalExpr(e.Children[0], bind, intBits), evalExpr(e.Children[2], bind, intBits)
+		switch e.Children[1].Value.(string) {
+		case "*":
+			return (l * r) & mask
+		case "/\\":
+			return l & r
+		case ">>":
+			return (l >> uint(r)) & mask
+		case "<<":
+			return (l << uint(r)) & mask
+		}
+	}
+	notify.Fatalf("Internal error: fuzzer's reference evaluator hit unexpected node %s", e.Type)
+	return 0 // Unreachable.
+}
+
+// answersEqual reports whether two sorted answer-set slices are identical.
+func answersEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// shrink repeatedly tries to delete a body goal or halve a numeral literal
+// toward zero in cs, keeping each reduction only if the AST still diverges,
+// until no such reduction shrinks it further.
+func shrink(nm string, cs []*ASTNode, p *Parameters, cfg FuzzConfig) (string, []*ASTNode) {
+	for progress := true; progress; {
+		progress = false
+		c := cs[0]
+
+		for i := 1; i < len(c.Children); i++ {
+			trial := &ASTNode{Type: ClauseType}
+			trial.Children = append(append([]*ASTNode{}, c.Children[:i]...), c.Children[i+1:]...)
+			if div, _ := diverges(nm, []*ASTNode{trial}, p, cfg); div != "" {
+				c = trial
+				progress = true
+			}
+		}
+
+		// Constant-lowering: halve each numeral literal toward zero,
+		// on the theory that a smaller constant is as good a
+		// counterexample and easier to read.  Operate on a deep copy
+		// so a failed trial doesn't corrupt c, matched back up to the
+		// original numeral by FindByType's deterministic traversal
+		// order.
+		for i, num := range c.FindByType(NumeralType) {
+			v := num.Value.(int)
+			if v == 0 {
+				continue
+			}
+			trial := deepCopyNode(c)
+			tNum := trial.FindByType(NumeralType)[i]
+			tNum.Value = v / 2
+			tNum.Text = strconv.Itoa(v / 2)
+			if div, _ := diverges(nm, []*ASTNode{trial}, p, cfg); div != "" {
+				c = trial
+				progress = true
+			}
+		}
+
+		cs[0] = c
+	}
+	return nm, cs
+}
+
+// deepCopyNode returns a deep copy of n, letting shrink mutate a trial AST
+// (e.g., lower a numeral's value) without disturbing the original if the
+// trial turns out not to still diverge.
+func deepCopyNode(n *ASTNode) *ASTNode {
+	cp := *n
+	if n.Children != nil {
+		cp.Children = make([]*ASTNode, len(n.Children))
+		for i, c := range n.Children {
+			cp.Children[i] = deepCopyNode(c)
+		}
+	}
+	return &cp
+}
+
+// prologSyntax renders a shrunk clause group back to Prolog source for
+// reporting a counterexample.
+func prologSyntax(nm string, cs []*ASTNode) string {
+	c := cs[0]
+	head := c.Children[0]
+	args := make([]string, len(head.Children)-1)
+	for i, t := range head.Children[1:] {
+		args[i] = t.Text
+	}
+	s := fmt.Sprintf("%s(%s)", nm, strings.Join(args, ", "))
+	if len(c.Children) > 1 {
+		goals := make([]string, len(c.Children)-1)
+		for i, g := range c.Children[1:] {
+			goals[i] = goalSyntax(g)
+		}
+		s += " :- " + strings.Join(goals, ", ")
+	}
+	return s + "."
+}
+
+// goalSyntax renders a single body goal back to Prolog source.
+func goalSyntax(g *ASTNode) string {
+	return exprSyntax(g.Children[0]) + " " + g.Children[1].Value.(string) + " " + exprSyntax(g.Children[2])
+}
+
+// exprSyntax renders a single arithmetic expression back to Prolog source.
+func exprSyntax(e *ASTNode) string {
+	switch e.Type {
+	case NumeralType, VariableType:
+		return e.Text
+	case UnaryExprType:
+		if len(e.Children) == 1 {
+			return exprSyntax(e.Children[0])
+		}
+		return e.Children[0].Value.(string) + exprSyntax(e.Children[1])
+	case AdditiveExprType, MultiplicativeExprType:
+		if len(e.Children) == 1 {
+			return exprSyntax(e.Children[0])
+		}
+		return exprSyntax(e.Children[0]) + " " + e.Children[1].Value.(string) + " " + exprSyntax(e.Children[2])
+	}
+	return "?"
+}
@@ -2,17 +2,76 @@
 
 package main
 
-import "sort"
+import (
+	"sort"
+	"strconv"
+)
+
+// listFunctor is the pseudo-functor name used to size and tag Prolog lists,
+// which lower to bit-packed records the same way structures do but have no
+// functor atom of their own.  It is registered as a symbol (via symbolID)
+// the same way a real structure functor is, so it must be spelled so that
+// no legal Prolog atom -- which always starts with a lowercase letter --
+// can ever collide with it and alias its tag.
+const listFunctor = "_list"
+
+// compoundWidth records the bit-packed layout inferred for one compound
+// (list or structure) functor: the number of IntBits-wide element slots to
+// allocate after the tag field that identifies the functor.
+type compoundWidth struct {
+	Arity int // Number of element slots (list length or structure arity)
+}
 
 // RejectUnimplemented rejects the AST (i.e., aborts the program) if it
-// contains elements we do not currently know how to process.
+// contains elements we do not currently know how to process.  Lists and
+// structures no longer land here: InferCompoundWidths sizes them instead, so
+// WriteVerilog can lower them to fixed-width bit-packed records.
 func (a *ASTNode) RejectUnimplemented(p *Parameters) {
-	if n := a.FindByType(ListType); len(n) > 0 {
-		ParseError(n[0].Pos, "Lists are not currently supported")
+	p.CompoundWidths = a.InferCompoundWidths(p)
+	p.DeadClauses = a.AnalyzeModes(p)
+}
+
+// InferCompoundWidths scans an AST for ListType and StructureType nodes and
+// determines, for each functor, the largest number of elements it is ever
+// used with.  WriteVerilog uses the result to size the fixed-width
+// bit-packed record it lowers each list or structure to.  As a side effect,
+// every functor encountered is registered as a symbol (via symbolID) so it
+// receives a tag value in p.IntToSym.  If p.MaxListLen is set, list records
+// are sized for exactly that many elements and a longer list is an error.
+func (a *ASTNode) InferCompoundWidths(p *Parameters) map[string]*compoundWidth {
+	widths := make(map[string]*compoundWidth)
+	grow := func(nm string, n int) {
+		symbolID(nm, p)
+		w, ok := widths[nm]
+		if !ok {
+			w = &compoundWidth{}
+			widths[nm] = w
+		}
+		if n > w.Arity {
+			w.Arity = n
+		}
 	}
-	if n := a.FindByType(StructureType); len(n) > 0 {
-		ParseError(n[0].Pos, "Structures are not currently supported")
+	var walker func(n *ASTNode)
+	walker = func(n *ASTNode) {
+		switch n.Type {
+		case ListType:
+			ln := len(n.Children)
+			if p.MaxListLen > 0 && ln > int(p.MaxListLen) {
+				ParseError(n.Pos, "List of length %d exceeds -max-list-len=%d", ln, p.MaxListLen)
+			}
+			if p.MaxListLen > 0 {
+				ln = int(p.MaxListLen)
+			}
+			grow(listFunctor, ln)
+		case StructureType:
+			grow(n.Children[0].Value.(string), len(n.Children)-1)
+		}
+		for _, c := range n.Children {
+			walker(c)
+		}
 	}
+	walker(a)
+	return widths
 }
 
 // FindByType walks an AST and returns a list of all nodes of a given type.
@@ -68,6 +127,197 @@ func (a *ASTNode) uniqueAtomNames(names map[string]struct{}) {
 	}
 }
 
+// AnalyzeModes drops clauses whose body is statically unsatisfiable,
+// constant-folds arithmetic whose variables are all known ground constants
+// (including those a preceding is/2 goal in the same clause computes), and
+// aborts with a clear error if a predicate mixes a compound-term output in
+// one clause with an unconstrained variable in the same argument position
+// in another -- such an argument has no static bound on its width and so
+// cannot be synthesized to a finite number of qubits.  Clauses judged dead
+// are returned rather than removed from p.TopLevel, so writeClauseGroup can
+// skip emitting their $vN wires while still reporting accurate diagnostics
+// against the original source.
+func (a *ASTNode) AnalyzeModes(p *Parameters) (dead map[*ASTNode]bool) {
+	dead = make(map[*ASTNode]bool)
+
+	for nm, cs := range p.TopLevel {
+		arity := len(cs[0].Children[0].Children) - 1
+		sawCompound := make([]bool, arity)
+		sawFreeVar := make([]bool, arity)
+
+		for _, c := range cs {
+			// Fixpoint over the clause's own body: a variable is a
+			// known constant once every variable the "is" goal that
+			// computes it depends on is itself a known constant.
+			constVals := make(map[string]int)
+			for changed := true; changed; {
+				changed = false
+				for _, g := range c.Children[1:] {
+					if g.Type != RelationType || g.Children[1].Value.(string) != "is" {
+						continue
+					}
+					lhs := g.Children[0]
+					if lhs.Type != VariableType {
+						continue
+					}
+					name := lhs.Value.(string)
+					if _, done := constVals[name]; done {
+						continue
+					}
+					if val, ok := constValue(g.Children[2], constVals); ok {
+						constVals[name] = val
+						changed = true
+					}
+				}
+			}
+
+			foldConstants(c, constVals)
+
+			// A clause whose body constant-folds to a
+			// statically false goal can never be satisfied.
+			for _, g := range c.Children[1:] {
+				if val, ok := foldRelation(g, constVals); ok && !val {
+					dead[c] = true
+				}
+			}
+			if dead[c] {
+				continue
+			}
+
+			for i, t := range c.Children[0].Children[1:] {
+				switch t.Type {
+				case ListType, StructureType:
+					sawCompound[i] = true
+				case VariableType:
+					if _, ok := constVals[t.Value.(string)]; !ok {
+						sawFreeVar[i] = true
+					}
+				}
+			}
+		}
+
+		for i := 0; i < arity; i++ {
+			if sawCompound[i] && sawFreeVar[i] {
+				ParseError(cs[0].Pos, "Argument %d of %s/%d has an unbounded output mode: "+
+					"it is a list or structure in one clause and an unconstrained variable in "+
+					"another, so its width cannot be determined", i+1, nm, arity)
+			}
+		}
+	}
+	return dead
+}
+
+// constValue evaluates expression e to an integer if every variable it
+// contains is a known constant per constVals (e.g., one an earlier is/2
+// goal in the same clause computed), reporting false if it depends on any
+// other variable.
+func constValue(e *ASTNode, constVals map[string]int) (int, bool) {
+	switch e.Type {
+	case NumeralType:
+		return e.Value.(int), true
+	case VariableType:
+		v, ok := constVals[e.Value.(string)]
+		return v, ok
+	case PrimaryExprType:
+		return constValue(e.Children[0], constVals)
+	case UnaryExprType:
+		if len(e.Children) == 1 {
+			return constValue(e.Children[0], constVals)
+		}
+		v, ok := constValue(e.Children[1], constVals)
+		if !ok {
+			return 0, false
+		}
+		switch e.Children[0].Value.(string) {
+		case "-":
+			return -v, true
+		case "\\":
+			return ^v, true
+		}
+		return 0, false
+	case AdditiveExprType, MultiplicativeExprType:
+		if len(e.Children) == 1 {
+			return constValue(e.Children[0], constVals)
+		}
+		l, lok := constValue(e.Children[0], constVals)
+		r, rok := constValue(e.Children[2], constVals)
+		if !lok || !rok {
+			return 0, false
+		}
+		switch e.Children[1].Value.(string) {
+		case "+":
+			return l + r, true
+		case "-":
+			return l - r, true
+		case "*":
+			return l * r, true
+		case "/\\":
+			return l & r, true
+		case "\\/":
+			return l | r, true
+		case "xor":
+			return l ^ r, true
+		case ">>":
+			return l >> uint(r), true
+		case "<<":
+			return l << uint(r), true
+		default:
+			return 0, false // "/", "//", and "mod" always need runtime division-by-zero checking.
+		}
+	default:
+		return 0, false
+	}
+}
+
+// foldRelation evaluates relational goal g to a boolean if both of its
+// sides are constant per constVals, reporting false in its second result if
+// either side still depends on a non-constant variable.
+func foldRelation(g *ASTNode, constVals map[string]int) (bool, bool) {
+	if g.Type != RelationType {
+		return false, false
+	}
+	l, lok := constValue(g.Children[0], constVals)
+	r, rok := constValue(g.Children[2], constVals)
+	if !lok || !rok {
+		return false, false
+	}
+	switch g.Children[1].Value.(string) {
+	case "<=":
+		return l <= r, true
+	case ">=":
+		return l >= r, true
+	case "<":
+		return l < r, true
+	case ">":
+		return l > r, true
+	case "=", "is":
+		return l == r, true
+	case "\\=":
+		return l != r, true
+	default:
+		return false, false
+	}
+}
+
+// foldConstants rewrites, in place, any arithmetic subtree of n whose value
+// is fully determined by constVals (numerals and known-constant variables)
+// into a single NumeralType node, so WriteVerilog emits a literal instead of
+// redundant Verilog arithmetic.
+func foldConstants(n *ASTNode, constVals map[string]int) {
+	for _, c := range n.Children {
+		foldConstants(c, constVals)
+	}
+	switch n.Type {
+	case AdditiveExprType, MultiplicativeExprType, UnaryExprType:
+		if val, ok := constValue(n, constVals); ok {
+			n.Type = NumeralType
+			n.Children = nil
+			n.Value = val
+			n.Text = strconv.Itoa(val)
+		}
+	}
+}
+
 // MaxNumeral returns the maximum-valued numeric literal.
 func (a *ASTNode) MaxNumeral() int {
 	// Process the current node.
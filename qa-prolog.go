@@ -22,9 +22,15 @@ func BaseName(filename string) string {
 
 // Parameters encapsulates all program parameters.
 type Parameters struct {
-	ProgName   string // Name of this program
-	InFileName string // Name of the input file
-	IntBits    uint   // Number of bits to use for each program integer
+	ProgName       string                    // Name of this program
+	InFileName     string                    // Name of the input file
+	IntBits        uint                      // Number of bits to use for each program integer
+	MaxListLen     uint                      // Maximum list length to size bit-packed list records for (0 = infer from input)
+	CompoundWidths map[string]*compoundWidth // Per-functor layout of lowered lists and structures, set by RejectUnimplemented
+	TestbenchFile  string                    // Name of an optional companion testbench file to write, or "" for none
+	TestVectors    uint                      // Cap on input combinations the testbench tries per argument (0 = full 2^IntBits domain)
+	DeadClauses    map[*ASTNode]bool         // Clauses AnalyzeModes proved statically unsatisfiable
+	Format         string                    // Output backend: "verilog" or "edif"
 }
 
 // ParseError reports a parse error at a given position.
@@ -40,7 +46,30 @@ func main() {
 		flag.PrintDefaults()
 	}
 	flag.UintVar(&p.IntBits, "int-bits", 0, "Minimum integer width in bits")
+	flag.UintVar(&p.MaxListLen, "max-list-len", 0, "Maximum list length to size bit-packed list records for (0 = infer from input)")
+	flag.StringVar(&p.TestbenchFile, "testbench", "", "Write an exhaustive testbench that prints every satisfying assignment to the given file")
+	flag.UintVar(&p.TestVectors, "testbench-vectors", 0, "Cap the number of input combinations the testbench tries per argument (0 = full 2^int-bits domain)")
+	fuzzIters := flag.Int("fuzz", 0, "Run this many random-testing iterations against the compiler instead of compiling a file")
+	fuzzSeed := flag.Int64("fuzz-seed", 1, "Random-number seed to fuzz with")
+	fuzzMaxArity := flag.Int("fuzz-max-arity", 3, "Largest clause arity to fuzz with")
+	fuzzIverilog := flag.String("fuzz-iverilog", "iverilog", "Path to the iverilog compiler used to cross-check fuzzing results")
+	fuzzVvp := flag.String("fuzz-vvp", "vvp", "Path to the vvp simulator used to cross-check fuzzing results")
+	flag.StringVar(&p.Format, "format", "verilog", `Output backend to use: "verilog" or "edif"`)
 	flag.Parse()
+	if *fuzzIters > 0 {
+		intBits := p.IntBits
+		if intBits == 0 {
+			intBits = 4
+		}
+		Fuzz(*fuzzIters, FuzzConfig{
+			Seed:     *fuzzSeed,
+			IntBits:  intBits,
+			MaxArity: *fuzzMaxArity,
+			Iverilog: *fuzzIverilog,
+			Vvp:      *fuzzVvp,
+		})
+		return
+	}
 	if flag.NArg() == 0 {
 		p.InFileName = "<stdin>"
 	} else {
@@ -70,8 +99,25 @@ func main() {
 	ast := a.(*ASTNode)
 	ast.RejectUnimplemented(&p)
 
-	// Temporary
-	fmt.Println(ast)
-	fmt.Printf("ATOMS: %v\n", ast.AtomNames())
-	fmt.Printf("MAX NUM: %d\n", ast.MaxNumeral())
+	// Compile the AST to the selected backend.
+	switch p.Format {
+	case "verilog":
+		ast.WriteVerilog(os.Stdout, &p)
+	case "edif":
+		ast.WriteEDIF(os.Stdout, &p)
+	default:
+		notify.Fatalf(`Unrecognized -format %q; expected "verilog" or "edif"`, p.Format)
+	}
+
+	// Optionally write a companion testbench that exhaustively exercises
+	// the compiled module, for cross-checking against a classical
+	// simulator before spending qubit time on the annealer.
+	if p.TestbenchFile != "" {
+		tb, err := os.Create(p.TestbenchFile)
+		if err != nil {
+			notify.Fatal(err)
+		}
+		defer tb.Close()
+		ast.WriteTestbench(tb, &p)
+	}
 }
@@ -0,0 +1,98 @@
+// Output an AST as a Verilog testbench that prints every satisfying assignment.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteTestbench writes a testbench that exhaustively drives every top-level
+// predicate group in p.TopLevel with IntBits-wide input combinations (capped
+// at p.TestVectors per argument, if set) and $displays every satisfying
+// assignment in Prolog answer syntax.  Running the result through an
+// Icarus-compatible simulator lets a user cross-check the compiled module
+// against a classical interpretation before spending qubit time on the
+// quantum-annealer lowering.
+func (a *ASTNode) WriteTestbench(w io.Writer, p *Parameters) {
+	fmt.Fprintf(w, "// Testbench for Verilog version of Prolog program %s\n", p.InFileName)
+	fmt.Fprintf(w, "// Generated by %s\n", p.ProgName)
+	fmt.Fprintln(w, "`timescale 1ns/1ns")
+	fmt.Fprintln(w, "module testbench;")
+	var steps uint64
+	for nm, cs := range p.TopLevel {
+		a.writeTestbenchGroup(w, p, nm, cs)
+
+		// Each group's loops advance time by 1 per input combination.
+		_, vArgs := cs[0].args()
+		n := uint64(1)
+		for range vArgs {
+			n *= vectorLimit(p)
+		}
+		if n > steps {
+			steps = n
+		}
+	}
+	fmt.Fprintf(w, "\n  initial #%d $finish;\n", steps+1)
+	fmt.Fprintln(w, "endmodule")
+}
+
+// vectorLimit returns the number of values to try for each IntBits-wide
+// input: the full 2^IntBits domain, or p.TestVectors if it is set to
+// something smaller.
+func vectorLimit(p *Parameters) uint64 {
+	full := uint64(1) << p.IntBits
+	if p.TestVectors > 0 && uint64(p.TestVectors) < full {
+		return uint64(p.TestVectors)
+	}
+	return full
+}
+
+// writeTestbenchGroup instantiates one predicate group's compiled module and
+// nests one "for" loop per argument to exhaustively drive it, up to
+// vectorLimit, printing a Prolog-syntax answer for every satisfying
+// assignment.  It reuses the same input widths writeClauseGroupHeader
+// established for the module being tested.
+func (a *ASTNode) writeTestbenchGroup(w io.Writer, p *Parameters, nm string, cs []*ASTNode) {
+	_, vArgs := cs[0].args()
+	regs := make([]string, len(vArgs))
+	cnts := make([]string, len(vArgs))
+	for i := range vArgs {
+		regs[i] = fmt.Sprintf("tb_%s_%d", nm, i)
+		cnts[i] = fmt.Sprintf("tb_%s_%d_cnt", nm, i)
+	}
+
+	widths := groupArgWidths(p, cs)
+
+	fmt.Fprintf(w, "\n  // Exhaustively test %s.\n", nm)
+	for i, r := range regs {
+		fmt.Fprintf(w, "  reg [%d:0] %s;\n", widths[i]-1, r)
+	}
+	for _, c := range cnts {
+		fmt.Fprintf(w, "  integer %s;\n", c)
+	}
+	fmt.Fprintf(w, "  wire tbvalid_%s;\n", nm)
+	fmt.Fprintf(w, "  \\%s tb_%s (%s, tbvalid_%s);\n", nm, nm, strings.Join(regs, ", "), nm)
+
+	fmt.Fprintln(w, "  initial begin")
+	indent := "    "
+	lim := vectorLimit(p)
+	for _, c := range cnts {
+		fmt.Fprintf(w, "%sfor (%s = 0; %s < %d; %s = %s + 1)\n", indent, c, c, lim, c, c)
+		indent += "  "
+	}
+	fmt.Fprintf(w, "%sbegin\n", indent)
+	for i, r := range regs {
+		fmt.Fprintf(w, "%s  %s = %s;\n", indent, r, cnts[i])
+	}
+	fmt.Fprintf(w, "%s  #1;\n", indent)
+	fields := make([]string, len(regs))
+	for i := range fields {
+		fields[i] = "%0d"
+	}
+	fmt.Fprintf(w, "%s  if (tbvalid_%s) $display(\"%s(%s).\", %s);\n",
+		indent, nm, nm, strings.Join(fields, ", "), strings.Join(regs, ", "))
+	fmt.Fprintf(w, "%send\n", indent)
+	fmt.Fprintln(w, "  end")
+}
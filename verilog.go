@@ -5,6 +5,8 @@ package main
 import (
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"unicode"
 )
 
@@ -25,6 +27,165 @@ func (a *ASTNode) writeSymbols(w io.Writer, p *Parameters) {
 	}
 }
 
+// symbolID returns the index sym occupies in p.IntToSym, registering it as a
+// new symbol first if it is not already present.  It is how compound-term
+// functors, which may not otherwise appear as atoms (e.g., listFunctor),
+// obtain a tag value alongside ordinary Prolog atoms.
+func symbolID(sym string, p *Parameters) int {
+	for i, s := range p.IntToSym {
+		if s == sym {
+			return i
+		}
+	}
+	p.IntToSym = append(p.IntToSym, sym)
+	return len(p.IntToSym) - 1
+}
+
+// tagBits returns the number of bits needed for a compound term's tag field
+// to distinguish every symbol defined in the program.
+func tagBits(p *Parameters) uint {
+	bits := uint(1)
+	for 1<<bits < len(p.IntToSym) {
+		bits++
+	}
+	return bits
+}
+
+// argWidth returns the number of bits needed to represent clause argument t:
+// p.IntBits for an ordinary term, or a tag-plus-slots record width for a
+// list or structure sized by InferCompoundWidths.
+func argWidth(t *ASTNode, p *Parameters) uint {
+	var nm string
+	switch t.Type {
+	case ListType:
+		nm = listFunctor
+	case StructureType:
+		nm = t.Children[0].Value.(string)
+	default:
+		return p.IntBits
+	}
+	cw, ok := p.CompoundWidths[nm]
+	if !ok {
+		return p.IntBits
+	}
+	return tagBits(p) + uint(cw.Arity)*p.IntBits
+}
+
+// writeCompoundFieldDefs defines the bit-field offsets used to index into
+// each bit-packed compound (list or structure) term: a TAG field identifying
+// the functor (whose value comes from writeSymbols) followed by one
+// E-numbered slot per element.
+func writeCompoundFieldDefs(w io.Writer, p *Parameters) {
+	if len(p.CompoundWidths) == 0 {
+		return
+	}
+	tag := tagBits(p)
+	names := make([]string, 0, len(p.CompoundWidths))
+	for nm := range p.CompoundWidths {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "\n// Define bit-field offsets for each compound term.")
+	for _, nm := range names {
+		cw := p.CompoundWidths[nm]
+		top := tag + uint(cw.Arity)*p.IntBits - 1
+		fmt.Fprintf(w, "`define %s_TAG_HI %d\n", nm, top)
+		fmt.Fprintf(w, "`define %s_TAG_LO %d\n", nm, uint(cw.Arity)*p.IntBits)
+		for i := 0; i < cw.Arity; i++ {
+			lo := uint(cw.Arity-1-i) * p.IntBits
+			fmt.Fprintf(w, "`define %s_E%d_HI %d\n", nm, i, lo+p.IntBits-1)
+			fmt.Fprintf(w, "`define %s_E%d_LO %d\n", nm, i, lo)
+		}
+	}
+}
+
+// compoundMatch returns the Verilog boolean expression that tests whether
+// bit-packed compound argument vArg unifies with head term t (a list or
+// structure), binding any Prolog variables t contains into ctx.p2v.  Nested
+// lists and structures are destructured recursively, but Verilog forbids
+// part-selecting off of another part-select, so a nested term's slot is
+// first materialized into its own wire (declared via ctx.extra, the same
+// mechanism instantiateDivider uses) before being sliced again: a nested
+// term has no parameter number of its own, only a bit range within its
+// parent's record.
+func compoundMatch(t *ASTNode, vArg string, ctx *evalContext) string {
+	var nm string
+	var elems []*ASTNode
+	switch t.Type {
+	case ListType:
+		nm = listFunctor
+		elems = t.Children
+	case StructureType:
+		nm = t.Children[0].Value.(string)
+		elems = t.Children[1:]
+	default:
+		notify.Fatalf("Internal error: compoundMatch called on %s", t.Type)
+	}
+	conds := []string{fmt.Sprintf("%s[`%s_TAG_HI:`%s_TAG_LO] == `%s", vArg, nm, nm, nm)}
+	for i, e := range elems {
+		slot := fmt.Sprintf("%s[`%s_E%d_HI:`%s_E%d_LO]", vArg, nm, i, nm, i)
+		switch e.Type {
+		case VariableType:
+			v := e.Value.(string)
+			if bound, ok := ctx.p2v[v]; ok {
+				conds = append(conds, slot+" == "+bound)
+			} else {
+				ctx.p2v[v] = slot
+			}
+		case NumeralType:
+			conds = append(conds, slot+" == "+e.Text)
+		case AtomType:
+			conds = append(conds, slot+" == `"+e.Value.(string))
+		case ListType, StructureType:
+			nested := fmt.Sprintf("$cm%d_%d", ctx.cNum, ctx.cmNum)
+			ctx.cmNum++
+			ctx.extra = append(ctx.extra,
+				fmt.Sprintf("  wire [%d:0] %s = %s;", argWidth(e, ctx.p)-1, nested, slot))
+			conds = append(conds, compoundMatch(e, nested, ctx))
+		}
+	}
+	return "(" + strings.Join(conds, " && ") + ")"
+}
+
+// writeDivider emits the restoring-division submodule shared by every use
+// of Prolog's /, //, and mod operators in is/2: the divider is declared once
+// as a module, and instantiateDivider instantiates it at each division site
+// instead of inlining division logic into that site's expression.  It
+// implements the shift-subtract-restore algorithm bit by bit, rather than
+// handing the annealer toolchain a behavioral / or %, since edif2qmasm has
+// no primitive for either.
+func writeDivider(w io.Writer, p *Parameters) {
+	n := p.IntBits
+	fmt.Fprintln(w, "\n// Restoring-division submodule shared by all is/2 uses of / and mod.")
+	fmt.Fprintf(w, "module \\div_mod (dividend, divisor, quotient, remainder, $valid);\n")
+	fmt.Fprintf(w, "  input [%d:0] dividend, divisor;\n", n-1)
+	fmt.Fprintf(w, "  output [%d:0] quotient, remainder;\n", n-1)
+	fmt.Fprintln(w, "  output $valid;")
+	fmt.Fprintln(w, "  assign $valid = (divisor != 0);")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "  // Shift-subtract-restore: feed dividend's bits into the remainder")
+	fmt.Fprintln(w, "  // MSB first, subtracting divisor and setting the matching quotient")
+	fmt.Fprintln(w, "  // bit whenever the partial remainder is big enough, restoring it")
+	fmt.Fprintln(w, "  // (i.e., leaving the subtraction undone) otherwise.")
+	fmt.Fprintln(w, "  integer i;")
+	fmt.Fprintf(w, "  reg [%d:0] q;\n", n-1)
+	fmt.Fprintf(w, "  reg [%d:0] r;\n", n)
+	fmt.Fprintln(w, "  always @* begin")
+	fmt.Fprintln(w, "    q = 0;")
+	fmt.Fprintln(w, "    r = 0;")
+	fmt.Fprintf(w, "    for (i = %d; i >= 0; i = i - 1) begin\n", n-1)
+	fmt.Fprintf(w, "      r = {r[%d:0], dividend[i]};\n", n-1)
+	fmt.Fprintln(w, "      if (r >= divisor) begin")
+	fmt.Fprintln(w, "        r = r - divisor;")
+	fmt.Fprintln(w, "        q[i] = 1;")
+	fmt.Fprintln(w, "      end")
+	fmt.Fprintln(w, "    end")
+	fmt.Fprintln(w, "  end")
+	fmt.Fprintln(w, "  assign quotient = $valid ? q : 0;")
+	fmt.Fprintf(w, "  assign remainder = $valid ? r[%d:0] : 0;\n", n-1)
+	fmt.Fprintln(w, "endmodule")
+}
+
 // numToVerVar converts a parameter number from 0-701 (e.g., 5) to a Verilog
 // variable (e.g., "\$E").
 func numToVerVar(n int) string {
@@ -59,20 +220,28 @@ func (c *ASTNode) args() (pArgs, vArgs []string) {
 // prologToVerilogUnary maps a Prolog unary operator to a Verilog unary
 // operator.
 var prologToVerilogUnary map[string]string = map[string]string{
-	"-": "-",
+	"-":  "-",
+	"\\": "~", // Bitwise not
 }
 
 // prologToVerilogAdd maps a Prolog additive operator to a Verilog additive
 // operator.
 var prologToVerilogAdd map[string]string = map[string]string{
-	"+": "+",
-	"-": "-",
+	"+":   "+",
+	"-":   "-",
+	"\\/": "|", // Bitwise or
+	"xor": "^",
 }
 
 // prologToVerilogMult maps a Prolog multiplicative operator to a Verilog
-// multiplicative operator.
+// multiplicative operator.  "/", "//", and "mod" are handled specially by
+// toVerilogExpr, since they lower to an instance of the shared div_mod
+// submodule rather than to an inline Verilog operator.
 var prologToVerilogMult map[string]string = map[string]string{
-	"*": "*",
+	"*":   "*",
+	"/\\": "&", // Bitwise and
+	">>":  ">>",
+	"<<":  "<<",
 }
 
 // prologToVerilogRel maps a Prolog relational operator to a Verilog relational
@@ -87,9 +256,53 @@ var prologToVerilogRel map[string]string = map[string]string{
 	"is":  "==",
 }
 
+// dividingOps are the Prolog multiplicative operators that require the
+// shared div_mod submodule rather than a native Verilog operator.
+var dividingOps = map[string]bool{
+	"/":   true,
+	"//":  true,
+	"mod": true,
+}
+
+// evalContext threads the state toVerilogExpr accumulates while lowering one
+// clause body: p2v maps Prolog variables to Verilog wires, and extra
+// collects any submodule instantiations (e.g., dividers) that the resulting
+// expression depends on and that writeClauseBody must declare before the
+// $vN wire that uses them.
+type evalContext struct {
+	p2v   map[string]string
+	p     *Parameters
+	cNum  int
+	cmNum int
+	extra []string
+}
+
+// instantiateDivider emits an instance of the shared div_mod submodule
+// (declared once by writeDivider) for one use of /, //, or mod, and returns
+// the wire carrying the requested result.  Division by zero clears the
+// instance's $valid output, which the caller folds into the clause's own
+// validity so a divide-by-zero simply fails to unify rather than crashing
+// the annealer.  Wires and the instance are named from both ctx.cNum and
+// the clause-local count so that no two clauses in the same writeClauseGroup
+// module -- which share one Verilog namespace -- collide.
+func (ctx *evalContext) instantiateDivider(dividend, divisor, op string) string {
+	n := len(ctx.extra) / 3
+	q := fmt.Sprintf("$dq%d_%d", ctx.cNum, n)
+	r := fmt.Sprintf("$dr%d_%d", ctx.cNum, n)
+	ok := fmt.Sprintf("$dok%d_%d", ctx.cNum, n)
+	ctx.extra = append(ctx.extra,
+		fmt.Sprintf("  wire [%d:0] %s, %s;", ctx.p.IntBits-1, q, r),
+		fmt.Sprintf("  wire %s;", ok),
+		fmt.Sprintf("  \\div_mod div%d_%d (%s, %s, %s, %s, %s);", ctx.cNum, n, dividend, divisor, q, r, ok))
+	if op == "mod" {
+		return r
+	}
+	return q
+}
+
 // toVerilogExpr recursively converts an AST, starting from a clause's body
 // predicate, to an expression.
-func (a *ASTNode) toVerilogExpr(p2v map[string]string) string {
+func (a *ASTNode) toVerilogExpr(ctx *evalContext) string {
 	switch a.Type {
 	case NumeralType:
 		return a.Text
@@ -98,7 +311,7 @@ func (a *ASTNode) toVerilogExpr(p2v map[string]string) string {
 		return a.Value.(string)
 
 	case VariableType:
-		v, ok := p2v[a.Value.(string)]
+		v, ok := ctx.p2v[a.Value.(string)]
 		if !ok {
 			notify.Fatalf("Internal error: Failed to convert variable %s from Prolog to Verilog", a.Value.(string))
 		}
@@ -133,7 +346,7 @@ func (a *ASTNode) toVerilogExpr(p2v map[string]string) string {
 		return v
 
 	case PrimaryExprType:
-		c := a.Children[0].toVerilogExpr(p2v)
+		c := a.Children[0].toVerilogExpr(ctx)
 		if a.Value.(string) == "()" {
 			return "(" + c + ")"
 		} else {
@@ -142,39 +355,43 @@ func (a *ASTNode) toVerilogExpr(p2v map[string]string) string {
 
 	case UnaryExprType:
 		if len(a.Children) == 1 {
-			return a.Children[0].toVerilogExpr(p2v)
+			return a.Children[0].toVerilogExpr(ctx)
 		} else {
-			return a.Children[0].toVerilogExpr(p2v) + a.Children[1].toVerilogExpr(p2v)
+			return a.Children[0].toVerilogExpr(ctx) + a.Children[1].toVerilogExpr(ctx)
 		}
 
 	case MultiplicativeExprType:
 		if len(a.Children) == 1 {
-			return a.Children[0].toVerilogExpr(p2v)
+			return a.Children[0].toVerilogExpr(ctx)
 		} else {
-			c1 := a.Children[0].toVerilogExpr(p2v)
-			v := a.Children[1].toVerilogExpr(p2v)
-			c2 := a.Children[2].toVerilogExpr(p2v)
+			op := a.Children[1].Value.(string)
+			c1 := a.Children[0].toVerilogExpr(ctx)
+			c2 := a.Children[2].toVerilogExpr(ctx)
+			if dividingOps[op] {
+				return ctx.instantiateDivider(c1, c2, op)
+			}
+			v := a.Children[1].toVerilogExpr(ctx)
 			return c1 + v + c2
 		}
 
 	case AdditiveExprType:
 		if len(a.Children) == 1 {
-			return a.Children[0].toVerilogExpr(p2v)
+			return a.Children[0].toVerilogExpr(ctx)
 		} else {
-			c1 := a.Children[0].toVerilogExpr(p2v)
-			v := a.Children[1].toVerilogExpr(p2v)
-			c2 := a.Children[2].toVerilogExpr(p2v)
+			c1 := a.Children[0].toVerilogExpr(ctx)
+			v := a.Children[1].toVerilogExpr(ctx)
+			c2 := a.Children[2].toVerilogExpr(ctx)
 			return c1 + " " + v + " " + c2
 		}
 
 	case RelationType:
-		c1 := a.Children[0].toVerilogExpr(p2v)
-		v := a.Children[1].toVerilogExpr(p2v)
-		c2 := a.Children[2].toVerilogExpr(p2v)
+		c1 := a.Children[0].toVerilogExpr(ctx)
+		v := a.Children[1].toVerilogExpr(ctx)
+		c2 := a.Children[2].toVerilogExpr(ctx)
 		return c1 + " " + v + " " + c2
 
 	case PredicateType, TermType:
-		return a.Children[0].toVerilogExpr(p2v)
+		return a.Children[0].toVerilogExpr(ctx)
 
 	default:
 		notify.Fatalf("Internal error: Unexpected AST node type %s", a.Type)
@@ -183,12 +400,18 @@ func (a *ASTNode) toVerilogExpr(p2v map[string]string) string {
 }
 
 // process converts each predicate in a clause to an assignment to a valid bit.
-func (c *ASTNode) process(p2v map[string]string) []string {
-	// Assign validity based on matches on any specified input symbols or
-	// numbers.
+func (c *ASTNode) process(ctx *evalContext) []string {
+	// Assign validity based on matches on any specified input symbols,
+	// numbers, or (bit-packed) lists and structures.
 	valid := make([]string, 0, len(c.Children))
+	terms := c.Children[0].Children[1:]
 	pArgs, vArgs := c.args()
 	for i, a := range pArgs {
+		switch terms[i].Type {
+		case ListType, StructureType:
+			valid = append(valid, compoundMatch(terms[i], vArgs[i], ctx))
+			continue
+		}
 		r0 := rune(a[0])
 		switch {
 		case unicode.IsLower(r0):
@@ -205,17 +428,46 @@ func (c *ASTNode) process(p2v map[string]string) []string {
 		}
 	}
 
-	// Assign validity based on each predicate in the clause's body.
+	// Assign validity based on each predicate in the clause's body.  Any
+	// divisions the predicate performs (via is/2) additionally constrain
+	// validity on their divisor being non-zero.
 	for _, p := range c.Children[1:] {
-		valid = append(valid, p.toVerilogExpr(p2v))
+		nExtra := len(ctx.extra)
+		valid = append(valid, p.toVerilogExpr(ctx))
+		for i := nExtra; i < len(ctx.extra); i += 3 {
+			valid = append(valid, fmt.Sprintf("$dok%d_%d", ctx.cNum, i/3))
+		}
 	}
 	return valid
 }
 
+// groupArgWidths returns, for each argument position of a group of clauses
+// that share a name and arity, the widest argWidth across every clause in
+// the group (not just cs[0]): one clause may destructure an argument as a
+// list/structure while another matches a narrower term (a numeral, an atom,
+// or a smaller compound) at the same position, and a port/reg/wire sized for
+// only one of them would silently truncate the other.  writeClauseGroupHeader, writeEDIFClauseGroup, and
+// writeTestbenchGroup all share this.
+func groupArgWidths(p *Parameters, cs []*ASTNode) []uint {
+	arity := len(cs[0].Children[0].Children) - 1
+	widths := make([]uint, arity)
+	for _, c := range cs {
+		terms := c.Children[0].Children[1:]
+		for i, t := range terms {
+			if aw := argWidth(t, p); aw > widths[i] {
+				widths[i] = aw
+			}
+		}
+	}
+	return widths
+}
+
 // writeClauseGroupHeader is used by writeClauseGroup to write a Verilog module
 // header.
 func (a *ASTNode) writeClauseGroupHeader(w io.Writer, p *Parameters, nm string, cs []*ASTNode) {
 	_, vArgs := cs[0].args()
+	widths := groupArgWidths(p, cs)
+
 	fmt.Fprintf(w, "// Define %s.\n", nm)
 	fmt.Fprintf(w, "module \\%s (", nm)
 	for i, a := range vArgs {
@@ -225,13 +477,12 @@ func (a *ASTNode) writeClauseGroupHeader(w io.Writer, p *Parameters, nm string,
 		fmt.Fprint(w, a)
 	}
 	fmt.Fprintln(w, ", $valid);")
-	if p.IntBits == 1 {
-		for _, a := range vArgs {
+	for i, a := range vArgs {
+		width := widths[i]
+		if width == 1 {
 			fmt.Fprintf(w, "  input %s;\n", a)
-		}
-	} else {
-		for _, a := range vArgs {
-			fmt.Fprintf(w, "  input [%d:0] %s;\n", p.IntBits-1, a)
+		} else {
+			fmt.Fprintf(w, "  input [%d:0] %s;\n", width-1, a)
 		}
 	}
 	fmt.Fprintln(w, "  output $valid;")
@@ -256,14 +507,19 @@ func (c *ASTNode) writeClauseBody(w io.Writer, p *Parameters, nm string, cNum in
 	}
 
 	// Convert the clause body to a list of Boolean Verilog
-	// expressions.
-	valid = append(valid, c.process(p2v)...)
+	// expressions, instantiating a div_mod submodule for each
+	// division or mod the body performs along the way.
+	ctx := &evalContext{p2v: p2v, p: p, cNum: cNum}
+	valid = append(valid, c.process(ctx)...)
 	if len(valid) == 0 {
 		// Although not normally used in practice, handle
 		// useless clauses that accept all inputs (e.g.,
 		// "stupid(A, B, C).").
 		valid = append(valid, "1'b1")
 	}
+	for _, e := range ctx.extra {
+		fmt.Fprintln(w, e)
+	}
 	fmt.Fprintf(w, "  wire [%d:0] $v%d;\n", len(valid)-1, cNum+1)
 	for i, v := range valid {
 		fmt.Fprintf(w, "  assign $v%d[%d] = %s;\n", cNum+1, i, v)
@@ -276,15 +532,24 @@ func (a *ASTNode) writeClauseGroup(w io.Writer, p *Parameters, nm string, cs []*
 	// Write a module header.
 	a.writeClauseGroupHeader(w, p, nm, cs)
 
-	// Assign validity conditions based on each clause in the clause group.
-	for i, c := range cs {
-		c.writeClauseBody(w, p, nm, i)
+	// Assign validity conditions based on each clause in the clause
+	// group, skipping any AnalyzeModes proved statically unsatisfiable.
+	live := 0
+	for _, c := range cs {
+		if p.DeadClauses[c] {
+			continue
+		}
+		c.writeClauseBody(w, p, nm, live)
+		live++
 	}
 
 	// Set the final validity bit to the intersection of all predicate
 	// validity bits.
 	fmt.Fprint(w, "  assign $valid = ")
-	for i := range cs {
+	if live == 0 {
+		fmt.Fprint(w, "1'b0")
+	}
+	for i := 0; i < live; i++ {
 		if i > 0 {
 			fmt.Fprint(w, " | ")
 		}
@@ -308,6 +573,13 @@ func (a *ASTNode) WriteVerilog(w io.Writer, p *Parameters) {
 	// Define constants for all of our symbols.
 	a.writeSymbols(w, p)
 
+	// Define the restoring-division submodule shared by all uses of /,
+	// //, and mod.
+	writeDivider(w, p)
+
+	// Define the bit-field layout of any lowered lists and structures.
+	writeCompoundFieldDefs(w, p)
+
 	// Write each clause in turn.
 	for nm, cs := range p.TopLevel {
 		fmt.Fprintln(w, "")